@@ -0,0 +1,144 @@
+package serialize
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression names the algorithm, if any, applied to a record's payload
+// after codec encoding. It is stored in DataHeader.Compression.
+type Compression uint8
+
+const (
+	// CompressionNone stores the codec output as-is.
+	CompressionNone Compression = iota
+	// CompressionSnappy compresses with github.com/golang/snappy.
+	CompressionSnappy
+	// CompressionZstd compresses with github.com/klauspost/compress/zstd.
+	CompressionZstd
+	// CompressionGzip compresses with the standard library compress/gzip.
+	CompressionGzip
+)
+
+// ErrUnknownCompression is returned for a Compression value this package
+// does not know how to (de)compress.
+var ErrUnknownCompression = errors.New("serialize: unknown compression")
+
+// ErrChecksumMismatch is returned by Unmarshal when the CRC32C recorded in
+// DataHeader.Checksum does not match the decompressed payload.
+var ErrChecksumMismatch = errors.New("serialize: checksum mismatch")
+
+// crc32cTable is the Castagnoli polynomial table used for the CRC32C
+// checksum recorded in DataHeader.Checksum.
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// checksumCRC32C returns the CRC32C (Castagnoli) checksum of data.
+func checksumCRC32C(data []byte) uint32 {
+	return crc32.Checksum(data, crc32cTable)
+}
+
+// compress encodes data with c, returning data unchanged for
+// CompressionNone.
+func compress(c Compression, data []byte) ([]byte, error) {
+	switch c {
+	case CompressionNone:
+		return data, nil
+
+	case CompressionSnappy:
+		buf := new(bytes.Buffer)
+		sw := snappy.NewWriter(buf)
+		if _, err := sw.Write(data); err != nil {
+			return nil, err
+		}
+		if err := sw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+
+	case CompressionZstd:
+		enc, err := zstd.NewWriter(nil)
+		if err != nil {
+			return nil, err
+		}
+		defer enc.Close()
+		return enc.EncodeAll(data, nil), nil
+
+	case CompressionGzip:
+		buf := new(bytes.Buffer)
+		gw := gzip.NewWriter(buf)
+		if _, err := gw.Write(data); err != nil {
+			return nil, err
+		}
+		if err := gw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+
+	default:
+		return nil, ErrUnknownCompression
+	}
+}
+
+// decompress wraps r with a decompressing reader for c, streaming the
+// decoded bytes rather than decoding all at once. The caller must Close
+// the returned io.ReadCloser — in particular zstd.Decoder holds background
+// goroutines that are only released on Close.
+func decompress(c Compression, r io.Reader) (io.ReadCloser, error) {
+	switch c {
+	case CompressionNone:
+		return ioutil.NopCloser(r), nil
+
+	case CompressionSnappy:
+		return ioutil.NopCloser(snappy.NewReader(r)), nil
+
+	case CompressionZstd:
+		dec, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+
+	case CompressionGzip:
+		return gzip.NewReader(r)
+
+	default:
+		return nil, ErrUnknownCompression
+	}
+}
+
+// readAllCompressed decompresses r per c and returns the raw bytes. The
+// decompressed size is bounded at MaxMarshalledSize: DataHeader.DataSize only
+// bounds the compressed bytes on the wire, so without this a small, highly
+// compressible payload (a decompression bomb) could exhaust memory.
+func readAllCompressed(c Compression, r io.Reader) ([]byte, error) {
+	return readAllCompressedLimit(c, r, MaxMarshalledSize)
+}
+
+// readAllCompressedLimit is readAllCompressed with limit factored out so
+// tests can exercise the bomb-rejection logic against a small limit instead
+// of allocating and decompressing a MaxMarshalledSize-sized payload.
+func readAllCompressedLimit(c Compression, r io.Reader, limit int64) ([]byte, error) {
+	decompressor, err := decompress(c, r)
+	if err != nil {
+		return nil, err
+	}
+	defer decompressor.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(decompressor, limit+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(data)) > limit {
+		return nil, ErrSizeLimitExceeded
+	}
+
+	return data, nil
+}
@@ -0,0 +1,222 @@
+package serialize
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// containerMagic marks the end of a Container footer so OpenContainer can
+// locate it by reading backwards from the end of the file, the way
+// SFNT/zip files keep their offset table at a known position near EOF.
+var containerMagic = [8]byte{'s', 'l', 'i', 'm', 'c', 'n', 't', 'r'}
+
+// ErrContainerMagicMismatch is returned when a file does not end with a
+// recognizable Container footer.
+var ErrContainerMagicMismatch = errors.New("serialize: not a Container, magic mismatch")
+
+// ErrKeyNotFound is returned by Container.Get when key has no entry.
+var ErrKeyNotFound = errors.New("serialize: key not found in container")
+
+// ErrDuplicateKey is returned by Container.Put when key was already used in
+// an earlier Put call on the same Container.
+var ErrDuplicateKey = errors.New("serialize: duplicate key in container")
+
+// ErrMalformedFooter is returned when a Container footer's entry count or a
+// key length doesn't fit the bytes actually present in the footer.
+var ErrMalformedFooter = errors.New("serialize: malformed container footer")
+
+// containerEntry locates one header+payload record inside a Container,
+// keyed by Key.
+type containerEntry struct {
+	Offset uint64
+	Size   uint64
+	Key    []byte
+}
+
+// Container packs many Marshal-style header+payload records into a single
+// io.WriterAt/io.ReaderAt file and appends an index footer of
+// []struct{Offset, Size uint64; Key []byte}, so entries can be looked up by
+// key in O(1) via UnmarshalAt instead of scanning every record.
+type Container struct {
+	entries []containerEntry
+	byKey   map[string]int
+}
+
+// NewContainer returns an empty Container ready to accept Put calls.
+func NewContainer() *Container {
+	return &Container{byKey: map[string]int{}}
+}
+
+// Put encodes obj with the Codec registered under codecID, writes it to w
+// at offset, and records key -> offset/size for the footer Finalize later
+// writes. Callers are responsible for choosing non-overlapping offsets
+// across Put calls, e.g. accumulating the returned cnt. key must be unique
+// across all Put calls on c; a repeat returns ErrDuplicateKey, since
+// silently keeping both the stale and new containerEntry would bloat the
+// footer and make Iter yield the same key twice.
+func (c *Container) Put(w io.WriterAt, offset int64, key []byte, obj interface{}, codecID CodecID) (cnt int64, err error) {
+	if _, exists := c.byKey[string(key)]; exists {
+		return 0, ErrDuplicateKey
+	}
+
+	n, err := MarshalAtCodec(w, offset, obj, codecID)
+	if err != nil {
+		return n, err
+	}
+
+	c.byKey[string(key)] = len(c.entries)
+	c.entries = append(c.entries, containerEntry{
+		Offset: uint64(offset),
+		Size:   uint64(n),
+		Key:    append([]byte(nil), key...),
+	})
+
+	return n, nil
+}
+
+// Finalize writes the index footer to w at offset, which must be the first
+// byte past the last entry written by Put, and returns the footer length.
+func (c *Container) Finalize(w io.WriterAt, offset int64) (cnt int64, err error) {
+	footerBuf := new(bytes.Buffer)
+
+	if err := binary.Write(footerBuf, binary.LittleEndian, uint64(len(c.entries))); err != nil {
+		return 0, err
+	}
+
+	for _, e := range c.entries {
+		if err := binary.Write(footerBuf, binary.LittleEndian, e.Offset); err != nil {
+			return 0, err
+		}
+		if err := binary.Write(footerBuf, binary.LittleEndian, e.Size); err != nil {
+			return 0, err
+		}
+		if err := binary.Write(footerBuf, binary.LittleEndian, uint64(len(e.Key))); err != nil {
+			return 0, err
+		}
+		if _, err := footerBuf.Write(e.Key); err != nil {
+			return 0, err
+		}
+	}
+
+	footerLen := uint64(footerBuf.Len())
+	if err := binary.Write(footerBuf, binary.LittleEndian, footerLen); err != nil {
+		return 0, err
+	}
+	if _, err := footerBuf.Write(containerMagic[:]); err != nil {
+		return 0, err
+	}
+
+	n, err := w.WriteAt(footerBuf.Bytes(), offset)
+	return int64(n), err
+}
+
+// OpenContainer reads the index footer from the end of a file of the given
+// size and returns a Container ready to serve Get/Iter. It reads only the
+// footer, not the entries themselves.
+func OpenContainer(r io.ReaderAt, size int64) (*Container, error) {
+	trailerLen := int64(8 + len(containerMagic)) // footerLen uint64 + magic
+
+	if size < trailerLen {
+		return nil, ErrContainerMagicMismatch
+	}
+
+	trailer := make([]byte, trailerLen)
+	if _, err := r.ReadAt(trailer, size-trailerLen); err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(trailer[8:], containerMagic[:]) {
+		return nil, ErrContainerMagicMismatch
+	}
+
+	footerLen := binary.LittleEndian.Uint64(trailer[:8])
+
+	// A truncated or adversarial trailer could set footerLen far beyond
+	// the bytes actually available before the trailer, which would wrap
+	// int64(footerLen) negative and let footerStart pass the sanity check
+	// below before make([]byte, footerLen) crashes the process. Bound it
+	// against size-trailerLen first, the same way keyLen is bounded
+	// against the footer's own remaining bytes further down.
+	if footerLen > uint64(size-trailerLen) {
+		return nil, ErrContainerMagicMismatch
+	}
+
+	footerStart := size - trailerLen - int64(footerLen)
+	if footerStart < 0 {
+		return nil, ErrContainerMagicMismatch
+	}
+
+	footerBuf := make([]byte, footerLen)
+	if _, err := r.ReadAt(footerBuf, footerStart); err != nil {
+		return nil, err
+	}
+
+	reader := bytes.NewReader(footerBuf)
+
+	var count uint64
+	if err := binary.Read(reader, binary.LittleEndian, &count); err != nil {
+		return nil, err
+	}
+
+	c := &Container{byKey: map[string]int{}}
+
+	for i := uint64(0); i < count; i++ {
+		var e containerEntry
+		if err := binary.Read(reader, binary.LittleEndian, &e.Offset); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(reader, binary.LittleEndian, &e.Size); err != nil {
+			return nil, err
+		}
+
+		var keyLen uint64
+		if err := binary.Read(reader, binary.LittleEndian, &keyLen); err != nil {
+			return nil, err
+		}
+
+		// A truncated or adversarial footer could set keyLen far beyond
+		// what's actually left to read, turning make([]byte, keyLen) into a
+		// crash/OOM before io.ReadFull ever gets a chance to error on the
+		// short read.
+		if keyLen > uint64(reader.Len()) {
+			return nil, ErrMalformedFooter
+		}
+
+		e.Key = make([]byte, keyLen)
+		if _, err := io.ReadFull(reader, e.Key); err != nil {
+			return nil, err
+		}
+
+		c.byKey[string(e.Key)] = len(c.entries)
+		c.entries = append(c.entries, e)
+	}
+
+	return c, nil
+}
+
+// Get looks up key's entry and unmarshals it into obj via UnmarshalAtCodec,
+// dispatching to whichever Codec the stored record's CodecID names — the
+// same codec Put was given, which may not be protobuf — giving O(1) random
+// access without scanning preceding entries.
+func (c *Container) Get(r io.ReaderAt, key []byte, obj interface{}) error {
+	idx, ok := c.byKey[string(key)]
+	if !ok {
+		return ErrKeyNotFound
+	}
+
+	_, err := UnmarshalAtCodec(r, int64(c.entries[idx].Offset), obj)
+	return err
+}
+
+// Iter calls fn for every key in the container, in Put order, stopping at
+// the first error fn returns.
+func (c *Container) Iter(fn func(key []byte, offset int64) error) error {
+	for _, e := range c.entries {
+		if err := fn(e.Key, int64(e.Offset)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package serialize
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+// memFile is a minimal in-memory io.Writer/io.WriterAt/io.ReaderAt, standing
+// in for an *os.File in tests. Write appends sequentially, independent of
+// woff, the same way *os.File's sequential writes don't disturb WriteAt.
+type memFile struct {
+	buf  []byte
+	woff int64
+}
+
+func (m *memFile) Write(p []byte) (int, error) {
+	n, err := m.WriteAt(p, m.woff)
+	m.woff += int64(n)
+	return n, err
+}
+
+func (m *memFile) WriteAt(p []byte, off int64) (int, error) {
+	end := off + int64(len(p))
+	if end > int64(len(m.buf)) {
+		grown := make([]byte, end)
+		copy(grown, m.buf)
+		m.buf = grown
+	}
+	copy(m.buf[off:], p)
+	return len(p), nil
+}
+
+func (m *memFile) ReadAt(p []byte, off int64) (int, error) {
+	if off >= int64(len(m.buf)) {
+		return 0, io.EOF
+	}
+	n := copy(p, m.buf[off:])
+	if n < len(p) {
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, nil
+}
+
+func TestOpenContainer_OversizedKeyLenRejected(t *testing.T) {
+	footer := new(bytes.Buffer)
+	binary.Write(footer, binary.LittleEndian, uint64(1))     // count
+	binary.Write(footer, binary.LittleEndian, uint64(0))     // Offset
+	binary.Write(footer, binary.LittleEndian, uint64(0))     // Size
+	binary.Write(footer, binary.LittleEndian, uint64(1<<40)) // keyLen, far beyond what follows
+
+	file := new(bytes.Buffer)
+	file.Write(footer.Bytes())
+	binary.Write(file, binary.LittleEndian, uint64(footer.Len()))
+	file.Write(containerMagic[:])
+
+	if _, err := OpenContainer(bytes.NewReader(file.Bytes()), int64(file.Len())); err != ErrMalformedFooter {
+		t.Fatalf("got err %v, want ErrMalformedFooter", err)
+	}
+}
+
+func TestOpenContainer_OversizedFooterLenRejected(t *testing.T) {
+	file := new(bytes.Buffer)
+	file.WriteString("not actually a footer, just filler bytes")
+	binary.Write(file, binary.LittleEndian, uint64(1)<<63+1000) // footerLen, far beyond the file itself
+	file.Write(containerMagic[:])
+
+	if _, err := OpenContainer(bytes.NewReader(file.Bytes()), int64(file.Len())); err != ErrContainerMagicMismatch {
+		t.Fatalf("got err %v, want ErrContainerMagicMismatch", err)
+	}
+}
+
+func TestContainer_Put_WriteErrorNotRecorded(t *testing.T) {
+	w := &failingWriterAt{failAfter: 100} // enough for the header, not the payload
+	c := NewContainer()
+
+	payload := bytes.Repeat([]byte("x"), 1000)
+	if _, err := c.Put(w, 0, []byte("k"), payload, CodecXDR); err != errFailingWriterAt {
+		t.Fatalf("got err %v, want errFailingWriterAt", err)
+	}
+
+	if len(c.entries) != 0 {
+		t.Fatalf("got %d entries after a failed Put, want 0", len(c.entries))
+	}
+	if _, exists := c.byKey["k"]; exists {
+		t.Fatalf("byKey still has an entry for a failed Put")
+	}
+}
+
+func TestContainer_Put_DuplicateKeyRejected(t *testing.T) {
+	f := &memFile{}
+	c := NewContainer()
+
+	if _, err := c.Put(f, 0, []byte("k"), []byte("first"), CodecXDR); err != nil {
+		t.Fatalf("first Put: %v", err)
+	}
+
+	if _, err := c.Put(f, 0, []byte("k"), []byte("second"), CodecXDR); err != ErrDuplicateKey {
+		t.Fatalf("got err %v, want ErrDuplicateKey", err)
+	}
+
+	if len(c.entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(c.entries))
+	}
+}
+
+func TestContainer_PutGetIter_MixedCodecs(t *testing.T) {
+	f := &memFile{}
+	c := NewContainer()
+
+	var offset int64
+
+	n, err := c.Put(f, offset, []byte("k-gob"), map[string]int{"a": 1}, CodecGob)
+	if err != nil {
+		t.Fatalf("Put gob: %v", err)
+	}
+	offset += n
+
+	n, err = c.Put(f, offset, []byte("k-xdr"), []byte("xdr payload"), CodecXDR)
+	if err != nil {
+		t.Fatalf("Put xdr: %v", err)
+	}
+	offset += n
+
+	if _, err := c.Finalize(f, offset); err != nil {
+		t.Fatalf("Finalize: %v", err)
+	}
+
+	opened, err := OpenContainer(f, int64(len(f.buf)))
+	if err != nil {
+		t.Fatalf("OpenContainer: %v", err)
+	}
+
+	var gotMap map[string]int
+	if err := opened.Get(f, []byte("k-gob"), &gotMap); err != nil {
+		t.Fatalf("Get gob: %v", err)
+	}
+	if gotMap["a"] != 1 {
+		t.Fatalf("got %v, want map[a:1]", gotMap)
+	}
+
+	var gotXDR []byte
+	if err := opened.Get(f, []byte("k-xdr"), &gotXDR); err != nil {
+		t.Fatalf("Get xdr: %v", err)
+	}
+	if string(gotXDR) != "xdr payload" {
+		t.Fatalf("got %q, want %q", gotXDR, "xdr payload")
+	}
+
+	var keys []string
+	err = opened.Iter(func(key []byte, offset int64) error {
+		keys = append(keys, string(key))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Iter: %v", err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("got %d keys from Iter, want 2", len(keys))
+	}
+
+	if err := opened.Get(f, []byte("missing"), &gotXDR); err != ErrKeyNotFound {
+		t.Fatalf("got err %v, want ErrKeyNotFound", err)
+	}
+}
@@ -3,7 +3,9 @@ package serialize
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"io"
+	"strings"
 	"unsafe"
 
 	"github.com/openacid/slim/version"
@@ -15,6 +17,17 @@ const (
 	MaxMarshalledSize int64 = 1024 * 1024 * 1024
 )
 
+// ErrIncompatibleVersion is returned when a header's major version does not
+// match this package's, meaning UnmarshalHeader has no idea how to
+// interpret even the fixed-size fields it knows about.
+var ErrIncompatibleVersion = errors.New("serialize: incompatible major version")
+
+// ErrMalformedHeader is returned when a header's Version field has no
+// room left for its NUL terminator, i.e. it cannot possibly be a valid
+// version string written by this package, or when its HeaderSize is
+// smaller than the Version and HeaderSize fields it must cover.
+var ErrMalformedHeader = errors.New("serialize: malformed header")
+
 /**
  * Compatiblity gurantee:
  *     - do NOT change type of fields
@@ -23,11 +36,22 @@ const (
  *     - only append fields
  *	   - only use fixed-size type, e.g. not int, use int32 or int64
  *	   - test Every version of dataHeader ever existed
+ *
+ * Forward compatibility: a header written by a newer minor/release version
+ * may carry fields this version does not know about yet. UnmarshalHeader
+ * decodes every field it recognizes and stashes whatever trailing bytes
+ * remain in UnknownTail, so a caller that just wants to pass the record
+ * through can re-marshal it without losing those bytes. Only a differing
+ * major version is treated as truly incompatible.
  */
 type DataHeader struct {
-	Version    [version.MAXLEN]byte // version.VERSION, major.minor.release
-	HeaderSize uint64               // the length in bytes of dataHeader size
-	DataSize   uint64               // the length in bytes of serialized data size
+	Version     [version.MAXLEN]byte // version.VERSION, major.minor.release
+	HeaderSize  uint64               // the length in bytes of dataHeader size
+	DataSize    uint64               // the length in bytes of serialized data size
+	CodecID     CodecID              // which registered Codec produced the payload, see codec.go
+	Checksum    uint32               // CRC32C of the uncompressed payload, 0 if the header predates this field
+	Compression Compression          // compression applied to the payload on top of CodecID, see compress.go
+	UnknownTail []byte               // raw bytes of fields newer than this package recognizes, see UnmarshalHeader
 }
 
 func bytesToString(buf []byte, delimter byte) string {
@@ -39,29 +63,53 @@ func bytesToString(buf []byte, delimter byte) string {
 	return string(buf[:delimPos])
 }
 
-func makeDataHeader(verStr string, headerSize uint64, dataSize uint64) *DataHeader {
+// majorVersion returns the leading "major" component of a "major.minor.release"
+// version string, e.g. "2" for "2.3.1".
+func majorVersion(verStr string) string {
+	if i := strings.IndexByte(verStr, '.'); i >= 0 {
+		return verStr[:i]
+	}
+	return verStr
+}
+
+func makeDataHeader(verStr string, headerSize uint64, dataSize uint64, codecID CodecID, checksum uint32, compression Compression, unknownTail []byte) (*DataHeader, error) {
+	// A well-formed Version field always has room for its NUL terminator;
+	// a corrupt or adversarial header can make verStr come back exactly
+	// version.MAXLEN bytes long (no terminator found), which must be
+	// reported as a data error, not crash the reader.
 	if len(verStr) >= version.MAXLEN {
-		panic("version length overflow")
+		return nil, ErrMalformedHeader
 	}
 
-	if verStr > version.VERSION {
-		panic("forward compatibility is not supported")
+	if majorVersion(verStr) != majorVersion(version.VERSION) {
+		return nil, ErrIncompatibleVersion
 	}
 
 	header := DataHeader{
-		HeaderSize: headerSize,
-		DataSize:   dataSize,
+		HeaderSize:  headerSize,
+		DataSize:    dataSize,
+		CodecID:     codecID,
+		Checksum:    checksum,
+		Compression: compression,
+		UnknownTail: unknownTail,
 	}
 
 	copy(header.Version[:], verStr)
 
-	return &header
+	return &header, nil
 }
 
-func makeDefaultDataHeader(dataSize uint64) *DataHeader {
+func makeDefaultDataHeader(dataSize uint64, codecID CodecID, checksum uint32, compression Compression) *DataHeader {
 	headerSize := GetMarshalHeaderSize()
 
-	return makeDataHeader(version.VERSION, uint64(headerSize), dataSize)
+	// version.VERSION is this package's own version, so a mismatch here
+	// can only be a programming error, not bad external data.
+	header, err := makeDataHeader(version.VERSION, uint64(headerSize), dataSize, codecID, checksum, compression, nil)
+	if err != nil {
+		panic(err)
+	}
+
+	return header
 }
 
 func UnmarshalHeader(reader io.Reader) (header *DataHeader, err error) {
@@ -83,25 +131,82 @@ func UnmarshalHeader(reader io.Reader) (header *DataHeader, err error) {
 		return nil, err
 	}
 
-	toRead := headerSize - version.MAXLEN - uint64(unsafe.Sizeof(headerSize))
+	minHeaderSize := uint64(version.MAXLEN) + uint64(unsafe.Sizeof(headerSize))
+	if headerSize < minHeaderSize {
+		// A corrupt or adversarial headerSize smaller than the fields it's
+		// supposed to cover would underflow toRead below into a value near
+		// 2^64, turning the next make([]byte, toRead) into a crash/OOM.
+		return nil, ErrMalformedHeader
+	}
+
+	toRead := headerSize - minHeaderSize
 	buf := make([]byte, toRead)
 
 	if _, err := io.ReadFull(reader, buf); err != nil {
 		return nil, err
 	}
 
-	var dataSize uint64
 	restReader := bytes.NewReader(buf)
-	err = binary.Read(restReader, binary.LittleEndian, &dataSize)
-	if err != nil {
+
+	var dataSize uint64
+	if err := binary.Read(restReader, binary.LittleEndian, &dataSize); err != nil {
+		return nil, err
+	}
+
+	var codecID CodecID
+	if err := binary.Read(restReader, binary.LittleEndian, &codecID); err != nil {
 		return nil, err
 	}
 
-	return makeDataHeader(verStr, headerSize, dataSize), nil
+	// Checksum and Compression were appended after the initial release of
+	// this header layout. Older headers simply don't carry these trailing
+	// bytes, so only read them if HeaderSize said they're there.
+	var checksum uint32
+	var compression Compression
+
+	if restReader.Len() >= int(unsafe.Sizeof(checksum)) {
+		if err := binary.Read(restReader, binary.LittleEndian, &checksum); err != nil {
+			return nil, err
+		}
+	}
+
+	if restReader.Len() >= int(unsafe.Sizeof(compression)) {
+		if err := binary.Read(restReader, binary.LittleEndian, &compression); err != nil {
+			return nil, err
+		}
+	}
+
+	// Whatever is left belongs to fields a newer writer appended that this
+	// version doesn't know how to interpret yet. Keep the raw bytes so a
+	// caller can round-trip them on re-marshal instead of silently
+	// dropping the newer writer's data.
+	unknownTail := make([]byte, restReader.Len())
+	if _, err := io.ReadFull(restReader, unknownTail); err != nil {
+		return nil, err
+	}
+
+	return makeDataHeader(verStr, headerSize, dataSize, codecID, checksum, compression, unknownTail)
 }
 
 func marshalHeader(writer io.Writer, header *DataHeader) (err error) {
-	return binary.Write(writer, binary.LittleEndian, header)
+	for _, field := range []interface{}{
+		header.Version,
+		header.HeaderSize,
+		header.DataSize,
+		header.CodecID,
+		header.Checksum,
+		header.Compression,
+	} {
+		if err := binary.Write(writer, binary.LittleEndian, field); err != nil {
+			return err
+		}
+	}
+
+	// UnknownTail is the raw bytes of fields newer than this package
+	// recognizes; writing it back verbatim is what lets a pass-through
+	// caller round-trip a header it doesn't fully understand.
+	_, err = writer.Write(header.UnknownTail)
+	return err
 }
 
 /**
@@ -109,17 +214,21 @@ func marshalHeader(writer io.Writer, header *DataHeader) (err error) {
  * So make a temp copy, and copy it to destination if everything is ok
  */
 func Marshal(writer io.Writer, obj proto.Message) (cnt int64, err error) {
-	marshaledData, err := proto.Marshal(obj)
-	if err != nil {
-		return 0, err
-	}
+	return MarshalCodec(writer, obj, CodecProtobuf)
+}
 
-	dataSize := uint64(len(marshaledData))
-	dataHeader := makeDefaultDataHeader(dataSize)
+// MarshalCodec is Marshal, but encodes obj with the Codec registered under
+// codecID instead of always using protobuf. The codecID is recorded in the
+// written DataHeader so UnmarshalCodec can later pick the matching Codec.
+func MarshalCodec(writer io.Writer, obj interface{}, codecID CodecID) (cnt int64, err error) {
+	return MarshalCodecCompressed(writer, obj, codecID, CompressionNone)
+}
 
-	// write to headerBuf to get cnt
-	headerBuf := new(bytes.Buffer)
-	err = marshalHeader(headerBuf, dataHeader)
+// MarshalCodecCompressed is MarshalCodec, additionally compressing the
+// encoded payload with compression and recording a CRC32C checksum of the
+// uncompressed bytes in the header so Unmarshal can detect corruption.
+func MarshalCodecCompressed(writer io.Writer, obj interface{}, codecID CodecID, compression Compression) (cnt int64, err error) {
+	headerBuf, payload, err := prepareRecord(obj, codecID, compression)
 	if err != nil {
 		return 0, err
 	}
@@ -129,22 +238,25 @@ func Marshal(writer io.Writer, obj proto.Message) (cnt int64, err error) {
 		return int64(nHeader), err
 	}
 
-	nData, err := writer.Write(marshaledData)
+	nData, err := writer.Write(payload)
 
 	return int64(nHeader + nData), err
 }
 
 func MarshalAt(writer io.WriterAt, offset int64, obj proto.Message) (cnt int64, err error) {
-	marshaledData, err := proto.Marshal(obj)
-	if err != nil {
-		return 0, err
-	}
+	return MarshalAtCodec(writer, offset, obj, CodecProtobuf)
+}
 
-	dataSize := uint64(len(marshaledData))
-	dataHeader := makeDefaultDataHeader(dataSize)
+// MarshalAtCodec is MarshalAt, but encodes obj with the Codec registered
+// under codecID instead of always using protobuf.
+func MarshalAtCodec(writer io.WriterAt, offset int64, obj interface{}, codecID CodecID) (cnt int64, err error) {
+	return MarshalAtCodecCompressed(writer, offset, obj, codecID, CompressionNone)
+}
 
-	headerBuf := new(bytes.Buffer)
-	err = marshalHeader(headerBuf, dataHeader)
+// MarshalAtCodecCompressed is MarshalAtCodec, additionally compressing the
+// payload and recording a CRC32C checksum, see MarshalCodecCompressed.
+func MarshalAtCodecCompressed(writer io.WriterAt, offset int64, obj interface{}, codecID CodecID, compression Compression) (cnt int64, err error) {
+	headerBuf, payload, err := prepareRecord(obj, codecID, compression)
 	if err != nil {
 		return 0, err
 	}
@@ -155,42 +267,112 @@ func MarshalAt(writer io.WriterAt, offset int64, obj proto.Message) (cnt int64,
 	}
 	offset += int64(nHeader)
 
-	nData, err := writer.WriteAt(marshaledData, offset)
+	nData, err := writer.WriteAt(payload, offset)
 
-	return int64(nHeader + nData), nil
+	return int64(nHeader + nData), err
+}
+
+// prepareRecord encodes obj with the codec registered under codecID,
+// checksums the uncompressed bytes, compresses them per compression, and
+// returns the marshaled DataHeader alongside the payload ready to write.
+func prepareRecord(obj interface{}, codecID CodecID, compression Compression) (headerBuf *bytes.Buffer, payload []byte, err error) {
+	codec, err := getCodec(codecID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rawData, err := codec.Marshal(obj)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	checksum := checksumCRC32C(rawData)
+
+	payload, err = compress(compression, rawData)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dataHeader := makeDefaultDataHeader(uint64(len(payload)), codecID, checksum, compression)
+
+	headerBuf = new(bytes.Buffer)
+	if err := marshalHeader(headerBuf, dataHeader); err != nil {
+		return nil, nil, err
+	}
+
+	return headerBuf, payload, nil
 }
 
 func Unmarshal(reader io.Reader, obj proto.Message) (err error) {
+	return UnmarshalCodec(reader, obj)
+}
+
+// UnmarshalCodec is Unmarshal, but dispatches to whichever Codec is named by
+// the DataHeader.CodecID it reads, rather than assuming protobuf. obj no
+// longer has to be a proto.Message; it just has to be accepted by that
+// Codec's Unmarshal.
+func UnmarshalCodec(reader io.Reader, obj interface{}) (err error) {
 	dataHeader, err := UnmarshalHeader(reader)
 	if err != nil {
 		return err
 	}
 
+	// Validate before allocating dataBuf: a corrupt or malicious header
+	// must not be able to force a huge allocation.
+	if dataHeader.DataSize > uint64(MaxMarshalledSize) {
+		return ErrSizeLimitExceeded
+	}
+
+	codec, err := getCodec(dataHeader.CodecID)
+	if err != nil {
+		return err
+	}
+
+	// Bound the read itself too, so a header lying about DataSize can't
+	// make us read past the intended record.
+	limited := io.LimitReader(reader, int64(dataHeader.DataSize))
+
 	dataBuf := make([]byte, dataHeader.DataSize)
 
 	// Repeat reader.Read until encounting an error or read full
 	//
 	// io.Reader:Read() does not guarantee to read all
 	// len(dataBuf)
-	if _, err := io.ReadFull(reader, dataBuf); err != nil {
+	if _, err := io.ReadFull(limited, dataBuf); err != nil {
 		return err
 	}
 
-	if err := proto.Unmarshal(dataBuf, obj); err != nil {
+	rawData, err := readAllCompressed(dataHeader.Compression, bytes.NewReader(dataBuf))
+	if err != nil {
 		return err
 	}
 
-	return nil
+	// Checksum was appended to DataHeader after CodecID; a header written
+	// before that still decodes, it just skips verification.
+	if dataHeader.HeaderSize >= uint64(GetMarshalHeaderSize()) {
+		if checksumCRC32C(rawData) != dataHeader.Checksum {
+			return ErrChecksumMismatch
+		}
+	}
+
+	return codec.Unmarshal(rawData, obj)
 }
 
 func UnmarshalAt(reader io.ReaderAt, offset int64, obj proto.Message) (n int64, err error) {
+	return UnmarshalAtCodec(reader, offset, obj)
+}
+
+// UnmarshalAtCodec is UnmarshalAt, but dispatches to whichever Codec is
+// named by the record's CodecID, same as UnmarshalCodec, instead of
+// assuming protobuf.
+func UnmarshalAtCodec(reader io.ReaderAt, offset int64, obj interface{}) (n int64, err error) {
 
 	// Wrap io.ReaderAt with a offset-self-maintained io.Reader
 	// The 3rd argument specifies right boundary. It is not buffer size related
 	// thus we just give it a big enough value.
 	r := io.NewSectionReader(reader, offset, MaxMarshalledSize)
 
-	err = Unmarshal(r, obj)
+	err = UnmarshalCodec(r, obj)
 	n, seekErr := r.Seek(0, io.SeekCurrent)
 	if seekErr != nil {
 		// It must be a programming error.
@@ -204,7 +386,8 @@ func UnmarshalAt(reader io.ReaderAt, offset int64, obj proto.Message) (n int64,
 }
 
 func GetMarshalHeaderSize() int64 {
-	return int64(unsafe.Sizeof(uint64(0))*2 + version.MAXLEN)
+	return int64(unsafe.Sizeof(uint64(0))*2 + version.MAXLEN + unsafe.Sizeof(CodecID(0)) +
+		unsafe.Sizeof(uint32(0)) + unsafe.Sizeof(Compression(0)))
 }
 
 func GetMarshalSize(obj proto.Message) int64 {
@@ -0,0 +1,118 @@
+package serialize
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/openacid/slim/version"
+)
+
+func TestUnmarshalHeader_RoundTripsUnknownTrailingFields(t *testing.T) {
+	header := makeDefaultDataHeader(5, CodecXDR, 0, CompressionNone)
+	header.UnknownTail = []byte{0xAA, 0xBB, 0xCC}
+	header.HeaderSize += uint64(len(header.UnknownTail))
+
+	original := new(bytes.Buffer)
+	if err := marshalHeader(original, header); err != nil {
+		t.Fatalf("marshalHeader: %v", err)
+	}
+
+	got, err := UnmarshalHeader(bytes.NewReader(original.Bytes()))
+	if err != nil {
+		t.Fatalf("UnmarshalHeader: %v", err)
+	}
+
+	if !bytes.Equal(got.UnknownTail, header.UnknownTail) {
+		t.Fatalf("UnknownTail = %v, want %v", got.UnknownTail, header.UnknownTail)
+	}
+
+	roundTripped := new(bytes.Buffer)
+	if err := marshalHeader(roundTripped, got); err != nil {
+		t.Fatalf("marshalHeader (round-trip): %v", err)
+	}
+
+	if !bytes.Equal(roundTripped.Bytes(), original.Bytes()) {
+		t.Fatalf("round-tripped header differs from original:\ngot  %v\nwant %v", roundTripped.Bytes(), original.Bytes())
+	}
+}
+
+func TestUnmarshalHeader_OldShorterHeaderStillDecodes(t *testing.T) {
+	// Simulate a header written before Checksum/Compression existed: no
+	// trailing bytes past CodecID.
+	legacySize := uint64(GetMarshalHeaderSize()) - 4 - 1
+
+	buf := new(bytes.Buffer)
+
+	verBuf := make([]byte, version.MAXLEN)
+	copy(verBuf, version.VERSION)
+	buf.Write(verBuf)
+
+	binary.Write(buf, binary.LittleEndian, legacySize)
+	binary.Write(buf, binary.LittleEndian, uint64(5)) // DataSize
+	binary.Write(buf, binary.LittleEndian, CodecXDR)  // CodecID, nothing after it
+
+	got, err := UnmarshalHeader(buf)
+	if err != nil {
+		t.Fatalf("UnmarshalHeader: %v", err)
+	}
+
+	if got.Checksum != 0 || got.Compression != CompressionNone {
+		t.Fatalf("expected zero-value Checksum/Compression for legacy header, got %+v", got)
+	}
+	if len(got.UnknownTail) != 0 {
+		t.Fatalf("expected empty UnknownTail for legacy header, got %v", got.UnknownTail)
+	}
+}
+
+func TestUnmarshalHeader_MalformedVersionReturnsError(t *testing.T) {
+	buf := new(bytes.Buffer)
+
+	// No NUL terminator anywhere in the version field: a corrupt or
+	// adversarial header must not crash the reader.
+	buf.Write(bytes.Repeat([]byte{0x41}, version.MAXLEN))
+
+	binary.Write(buf, binary.LittleEndian, uint64(GetMarshalHeaderSize()))
+	binary.Write(buf, binary.LittleEndian, uint64(0))
+	binary.Write(buf, binary.LittleEndian, CodecProtobuf)
+	binary.Write(buf, binary.LittleEndian, uint32(0))
+	binary.Write(buf, binary.LittleEndian, CompressionNone)
+
+	if _, err := UnmarshalHeader(buf); err != ErrMalformedHeader {
+		t.Fatalf("got err %v, want ErrMalformedHeader", err)
+	}
+}
+
+func TestUnmarshalHeader_UndersizedHeaderSizeReturnsError(t *testing.T) {
+	buf := new(bytes.Buffer)
+
+	verBuf := make([]byte, version.MAXLEN)
+	copy(verBuf, version.VERSION)
+	buf.Write(verBuf)
+
+	// headerSize smaller than version.MAXLEN+8 would underflow the toRead
+	// computation in UnmarshalHeader into a huge value.
+	binary.Write(buf, binary.LittleEndian, uint64(0))
+
+	if _, err := UnmarshalHeader(buf); err != ErrMalformedHeader {
+		t.Fatalf("got err %v, want ErrMalformedHeader", err)
+	}
+}
+
+func TestUnmarshalHeader_IncompatibleMajorVersionReturnsError(t *testing.T) {
+	buf := new(bytes.Buffer)
+
+	verBuf := make([]byte, version.MAXLEN)
+	copy(verBuf, "999.0.0")
+	buf.Write(verBuf)
+
+	binary.Write(buf, binary.LittleEndian, uint64(GetMarshalHeaderSize()))
+	binary.Write(buf, binary.LittleEndian, uint64(0))
+	binary.Write(buf, binary.LittleEndian, CodecProtobuf)
+	binary.Write(buf, binary.LittleEndian, uint32(0))
+	binary.Write(buf, binary.LittleEndian, CompressionNone)
+
+	if _, err := UnmarshalHeader(buf); err != ErrIncompatibleVersion {
+		t.Fatalf("got err %v, want ErrIncompatibleVersion", err)
+	}
+}
@@ -0,0 +1,149 @@
+package serialize
+
+import (
+	"bytes"
+	"hash/crc32"
+	"io"
+)
+
+// Encoder writes a stream of header+payload records to an underlying
+// io.Writer. When the destination also implements io.WriterAt (e.g. an
+// *os.File), Encode writes a placeholder header up front and patches the
+// real DataSize and Checksum back in once the payload length is known,
+// rather than requiring the caller to know the size in advance. The
+// payload itself is still fully marshaled in memory before any of it
+// reaches the writer — the Codec interface has no streaming Marshal — so
+// this buys self-patching headers, not a memory bound on the payload.
+//
+// A plain io.Writer destination can't be patched after the fact, so Encode
+// instead fully marshals the record before writing anything, keeping the
+// header's DataSize correct on the very first write and the stream
+// self-delimiting for a paired Decoder.
+type Encoder struct {
+	w       io.Writer
+	codecID CodecID
+	offset  int64
+}
+
+// NewEncoder returns an Encoder that writes to w using the default
+// protobuf Codec. Use WithCodec to select a different one.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w, codecID: CodecProtobuf}
+}
+
+// WithCodec selects the Codec used to encode subsequent Encode calls and
+// returns e for chaining.
+func (e *Encoder) WithCodec(codecID CodecID) *Encoder {
+	e.codecID = codecID
+	return e
+}
+
+// Encode writes obj as the next header+payload record.
+func (e *Encoder) Encode(obj interface{}) (cnt int64, err error) {
+	if wa, ok := e.w.(io.WriterAt); ok {
+		return e.encodeStreamed(wa, obj)
+	}
+
+	return e.encodeBuffered(obj)
+}
+
+// encodeBuffered marshals obj in full before writing anything, so the
+// header's DataSize is correct on the only write the destination gets.
+func (e *Encoder) encodeBuffered(obj interface{}) (cnt int64, err error) {
+	headerBuf, payload, err := prepareRecord(obj, e.codecID, CompressionNone)
+	if err != nil {
+		return 0, err
+	}
+
+	nHeader, err := e.w.Write(headerBuf.Bytes())
+	if err != nil {
+		return int64(nHeader), err
+	}
+
+	nData, err := e.w.Write(payload)
+
+	return int64(nHeader) + int64(nData), err
+}
+
+// encodeStreamed writes a placeholder header with DataSize and Checksum
+// reserved as zero, marshals obj on a goroutine feeding an io.Pipe whose
+// output is checksummed and copied to e.w in chunks, then patches the header
+// at its recorded offset once the real size and checksum are known. The
+// goroutine runs codec.Marshal to completion before its first pipe write,
+// so no payload bytes reach e.w until the whole payload is marshaled.
+func (e *Encoder) encodeStreamed(wa io.WriterAt, obj interface{}) (cnt int64, err error) {
+	codec, err := getCodec(e.codecID)
+	if err != nil {
+		return 0, err
+	}
+
+	recordOffset := e.offset
+
+	placeholder := makeDefaultDataHeader(0, e.codecID, 0, CompressionNone)
+	headerBuf := new(bytes.Buffer)
+	if err := marshalHeader(headerBuf, placeholder); err != nil {
+		return 0, err
+	}
+
+	nHeader, err := e.w.Write(headerBuf.Bytes())
+	e.offset += int64(nHeader)
+	if err != nil {
+		return int64(nHeader), err
+	}
+
+	// Marshal happens on a goroutine writing into a pipe; once the codec
+	// has returned the full payload, Encode forwards it to e.w in chunks
+	// through the checksum, without making a second full-size copy.
+	pr, pw := io.Pipe()
+	go func() {
+		data, mErr := codec.Marshal(obj)
+		if mErr != nil {
+			pw.CloseWithError(mErr)
+			return
+		}
+		_, wErr := pw.Write(data)
+		pw.CloseWithError(wErr)
+	}()
+
+	checksum := crc32.New(crc32cTable)
+	nData, err := io.Copy(e.w, io.TeeReader(pr, checksum))
+	e.offset += nData
+	if err != nil {
+		// io.Copy stopped reading pr, but the goroutine above may still be
+		// blocked inside pw.Write waiting for a reader. Close pr with an
+		// error so that Write unblocks (with io.ErrClosedPipe) instead of
+		// leaking the goroutine forever.
+		pr.CloseWithError(err)
+		return int64(nHeader) + nData, err
+	}
+
+	dataHeader := makeDefaultDataHeader(uint64(nData), e.codecID, checksum.Sum32(), CompressionNone)
+	patchBuf := new(bytes.Buffer)
+	if err := marshalHeader(patchBuf, dataHeader); err != nil {
+		return int64(nHeader) + nData, err
+	}
+	if _, err := wa.WriteAt(patchBuf.Bytes(), recordOffset); err != nil {
+		return int64(nHeader) + nData, err
+	}
+
+	return int64(nHeader) + nData, nil
+}
+
+// Decoder reads a stream of header+payload records written by Encoder or
+// Marshal. It enforces DataHeader.DataSize <= MaxMarshalledSize before
+// allocating the payload buffer, so a corrupt or hostile header can't OOM
+// the reader.
+type Decoder struct {
+	r io.Reader
+}
+
+// NewDecoder returns a Decoder reading records from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r}
+}
+
+// Decode reads the next header+payload record and unmarshals it into obj
+// using the Codec named by that record's CodecID.
+func (d *Decoder) Decode(obj interface{}) (err error) {
+	return UnmarshalCodec(d.r, obj)
+}
@@ -0,0 +1,133 @@
+package serialize
+
+import (
+	"bytes"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestEncoderDecoder_RoundTripOverPlainWriter(t *testing.T) {
+	buf := new(bytes.Buffer)
+
+	enc := NewEncoder(buf).WithCodec(CodecXDR)
+	records := [][]byte{[]byte("first"), []byte("second"), []byte("third")}
+
+	for i, r := range records {
+		if _, err := enc.Encode(r); err != nil {
+			t.Fatalf("Encode record %d: %v", i, err)
+		}
+	}
+
+	dec := NewDecoder(buf)
+	for i, want := range records {
+		var got []byte
+		if err := dec.Decode(&got); err != nil {
+			t.Fatalf("Decode record %d: %v", i, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("record %d: got %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestEncoderDecoder_RoundTripOverWriterAt(t *testing.T) {
+	f := &memFile{}
+
+	enc := NewEncoder(f).WithCodec(CodecXDR)
+	records := [][]byte{[]byte("first"), []byte("second"), []byte("third")}
+
+	var offset int64
+	for i, r := range records {
+		n, err := enc.Encode(r)
+		if err != nil {
+			t.Fatalf("Encode record %d: %v", i, err)
+		}
+		offset += n
+	}
+
+	// The streamed path must have patched each record's header with the
+	// real DataSize and Checksum, not left the placeholder zero values
+	// behind: decoding from the start of the buffer, as any ordinary
+	// io.Reader would, must still round-trip correctly.
+	dec := NewDecoder(bytes.NewReader(f.buf[:offset]))
+	for i, want := range records {
+		var got []byte
+		if err := dec.Decode(&got); err != nil {
+			t.Fatalf("Decode record %d: %v", i, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("record %d: got %q, want %q", i, got, want)
+		}
+	}
+}
+
+// failingWriterAt accepts the header write (a few bytes) but errors once
+// the payload write crosses failAfter bytes, simulating a downstream
+// WriterAt that dies partway through a large record.
+type failingWriterAt struct {
+	failAfter int64
+	written   int64
+}
+
+var errFailingWriterAt = errors.New("failingWriterAt: simulated write failure")
+
+func (f *failingWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	if f.written >= f.failAfter {
+		return 0, errFailingWriterAt
+	}
+	n := len(p)
+	if f.written+int64(n) > f.failAfter {
+		n = int(f.failAfter - f.written)
+	}
+	f.written += int64(n)
+	if n < len(p) {
+		return n, errFailingWriterAt
+	}
+	return n, nil
+}
+
+func (f *failingWriterAt) Write(p []byte) (int, error) {
+	return f.WriteAt(p, f.written)
+}
+
+// TestEncoder_EncodeStreamed_WriteErrorDoesNotLeakGoroutine guards against
+// io.Copy abandoning the pipe reader on a downstream write error while the
+// producer goroutine sits blocked forever inside pw.Write with nobody left
+// to read the rest of the payload.
+func TestEncoder_EncodeStreamed_WriteErrorDoesNotLeakGoroutine(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	payload := bytes.Repeat([]byte("x"), 10<<20) // 10MB, large enough to span multiple pipe reads
+	w := &failingWriterAt{failAfter: 100}
+
+	enc := NewEncoder(w).WithCodec(CodecXDR)
+	if _, err := enc.Encode(payload); err != errFailingWriterAt {
+		t.Fatalf("got err %v, want errFailingWriterAt", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		runtime.Gosched()
+		time.Sleep(time.Millisecond)
+	}
+
+	if got := runtime.NumGoroutine(); got > before {
+		t.Fatalf("goroutine count did not return to baseline: got %d, want <= %d", got, before)
+	}
+}
+
+func TestUnmarshalCodec_SizeLimitExceeded(t *testing.T) {
+	buf := new(bytes.Buffer)
+
+	header := makeDefaultDataHeader(uint64(MaxMarshalledSize)+1, CodecXDR, 0, CompressionNone)
+	if err := marshalHeader(buf, header); err != nil {
+		t.Fatalf("marshalHeader: %v", err)
+	}
+
+	var out []byte
+	if err := UnmarshalCodec(buf, &out); err != ErrSizeLimitExceeded {
+		t.Fatalf("got err %v, want ErrSizeLimitExceeded", err)
+	}
+}